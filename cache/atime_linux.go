@@ -0,0 +1,20 @@
+//go:build linux
+
+package cache
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// accessTime returns info's last-access time, falling back to its
+// modification time if info does not carry a *syscall.Stat_t. On a
+// filesystem mounted with noatime the stored atime is returned as is.
+func accessTime(info os.FileInfo) time.Time {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(st.Atim.Sec, st.Atim.Nsec)
+}
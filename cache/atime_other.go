@@ -0,0 +1,15 @@
+//go:build !linux
+
+package cache
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime returns info's modification time. Platforms other than
+// linux are not guaranteed to expose atime through os.FileInfo, so
+// mtime ordering is used as the next-best proxy for recency.
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
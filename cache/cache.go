@@ -1,39 +1,96 @@
 package cache
 
 import (
+	"container/list"
+	"context"
 	"errors"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sync/singleflight"
 )
 
 type Object struct {
 	path    string
 	data    []byte
 	lastMod time.Time
-}
 
-// Data returns a copy of the data held in Object.
-func (o *Object) Bytes() []byte {
-	return o.data[:]
-}
+	alias    string
+	cache    *Cache
+	uses     int64
+	diskHash string
 
-func (o *Object) CSS() template.CSS {
-	return template.CSS(o.data[:])
+	etag string
+	gz   []byte
 }
 
+// LastMod returns the time the data held by o was last updated.
+// Like every other field on Object, o.lastMod is mutated under the
+// owning Cache's lock (lastMod by Refresh; data, etag and gz also by
+// hydrate), so this reads it under that same lock rather than
+// dereferencing it directly.
 func (o *Object) LastMod() time.Time {
+	if o.cache == nil {
+		return o.lastMod
+	}
+	o.cache.mu.RLock()
+	defer o.cache.mu.RUnlock()
 	return o.lastMod
 }
 
+// objState is a point-in-time, race-free copy of the fields of an
+// Object that are mutated in place after insertion (by Refresh and
+// disk hydration). Callers that need more
+// than LastMod -- Handler, TypedCache -- must go through state
+// rather than reading obj.data/etag/gz directly, since those reads
+// would otherwise race with the locked writers.
+type objState struct {
+	data    []byte
+	lastMod time.Time
+	etag    string
+	gz      []byte
+}
+
+func (c *Cache) state(obj *Object) objState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return objState{
+		data:    obj.data,
+		lastMod: obj.lastMod,
+		etag:    obj.etag,
+		gz:      obj.gz,
+	}
+}
+
 type Cache struct {
 	mu      sync.RWMutex
 	mapping map[string]*Object
 	size    int64
+	sf      singleflight.Group
+
+	policy Policy
+	order  *list.List
+	elems  map[string]*list.Element
+	pinned map[string]bool
+	disk   *DiskTier
+
+	watchMu     sync.Mutex
+	dirWatches  map[string]dirWatch
+	fileWatches map[string]fileWatch
+	watcher     *fsnotify.Watcher
+	watchCancel context.CancelFunc
+
+	hits      int64
+	misses    int64
+	evictions int64
+	bytesIn   int64
+	bytesOut  int64
 
 	/*
 		MaxSize represents how large Cache may grow in bytes.
@@ -45,13 +102,35 @@ type Cache struct {
 		Therefore the precise memory footprint of Cache will
 		always be larger than MaxSize.
 
+		When adding data would push size past MaxSize, entries
+		are evicted per the active Policy (see SetPolicy) rather
+		than the add failing. Pinned entries (see Pin) are never
+		evicted.
+
 		A MaxSize of 0 is treated as infinite.
 	*/
 	MaxSize int64
+
+	/*
+		Precompress lists the file extensions (e.g. ".css", ".js")
+		that should have a gzip copy of their data precomputed and
+		stored alongside it, so Handler can serve it to clients
+		that send "Accept-Encoding: gzip" without allocating.
+
+		An empty Precompress disables precomputation entirely.
+	*/
+	Precompress []string
 }
 
 func New() *Cache {
-	return &Cache{mapping: make(map[string]*Object)}
+	return &Cache{
+		mapping:     make(map[string]*Object),
+		order:       list.New(),
+		elems:       make(map[string]*list.Element),
+		pinned:      make(map[string]bool),
+		dirWatches:  make(map[string]dirWatch),
+		fileWatches: make(map[string]fileWatch),
+	}
 }
 
 func (c *Cache) List() (aliases []string) {
@@ -68,11 +147,15 @@ func (c *Cache) MustAddDir(alias, dirPath string, exts []string, recursive bool)
 }
 
 func (c *Cache) MustAddFile(alias, filePath string) {
-	if err := c.AddFile(alias, filePath); err != nil {
+	if _, err := c.AddFile(alias, filePath); err != nil {
 		panic(err)
 	}
 }
 
+// AddDir walks dirPath, adding every file matching exts (all files
+// if exts is empty) under alias/<relative path>. recursive is
+// preserved alongside alias so Watch can re-scan this tree
+// deterministically when the filesystem changes beneath it.
 func (c *Cache) AddDir(alias, dirPath string, exts []string, recursive bool) error {
 
 	dirPath, err := filepath.Abs(dirPath)
@@ -80,6 +163,24 @@ func (c *Cache) AddDir(alias, dirPath string, exts []string, recursive bool) err
 		return err
 	}
 
+	if err := c.addDir(alias, dirPath, exts, recursive); err != nil {
+		return err
+	}
+
+	c.watchMu.Lock()
+	c.dirWatches[alias] = dirWatch{
+		alias:     alias,
+		dirPath:   dirPath,
+		exts:      exts,
+		recursive: recursive,
+	}
+	c.watchMu.Unlock()
+
+	return nil
+}
+
+func (c *Cache) addDir(alias, dirPath string, exts []string, recursive bool) error {
+
 	dir, err := ioutil.ReadDir(dirPath)
 	if err != nil {
 		return err
@@ -91,7 +192,7 @@ func (c *Cache) AddDir(alias, dirPath string, exts []string, recursive bool) err
 		dirPath := filepath.Join(dirPath, info.Name())
 
 		if info.IsDir() {
-			c.AddDir(alias, dirPath, exts, recursive)
+			c.addDir(alias, dirPath, exts, recursive)
 		}
 
 		if !info.Mode().IsRegular() {
@@ -103,8 +204,7 @@ func (c *Cache) AddDir(alias, dirPath string, exts []string, recursive bool) err
 			continue
 		}
 
-		err := c.AddFile(alias, dirPath)
-		if err != nil {
+		if _, err := c.addFile(alias, dirPath); err != nil {
 			return err
 		}
 	}
@@ -121,56 +221,140 @@ func in(ss []string, s string) bool {
 	return false
 }
 
-func (c *Cache) AddFile(alias, filePath string) error {
+// AddFile reads filePath into Cache under alias. If MaxSize would be
+// exceeded, entries are evicted per the active Policy (see
+// SetPolicy) until the new data fits; evicted is the list of
+// aliases removed this way. AddFile no longer errors when MaxSize is
+// exceeded -- it makes room instead.
+//
+// filePath's absolute path is recorded so that Watch can watch its
+// parent directory and reload or drop alias when the file changes or
+// disappears.
+func (c *Cache) AddFile(alias, filePath string) (evicted []string, err error) {
+
+	evicted, err = c.addFile(alias, filePath)
+	if err != nil {
+		return evicted, err
+	}
 
-	filePath, err := filepath.Abs(filePath)
+	absPath, err := filepath.Abs(filePath)
 	if err != nil {
-		return err
+		return evicted, err
 	}
 
-	info, err := os.Stat(filePath)
+	c.watchMu.Lock()
+	c.fileWatches[alias] = fileWatch{alias: alias, filePath: absPath}
+	c.watchMu.Unlock()
+
+	return evicted, nil
+}
+
+func (c *Cache) addFile(alias, filePath string) (evicted []string, err error) {
+
+	filePath, err = filepath.Abs(filePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if !info.Mode().IsRegular() {
-		return errors.New(fmt.Sprintf("%s is not a file", filePath))
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
 	}
 
-	if c.MaxSize > 0 && c.size+info.Size() > c.MaxSize {
-		return errors.New(fmt.Sprintf(
-			"cache exceeded MaxSize (%d bytes)", c.MaxSize))
+	if !info.Mode().IsRegular() {
+		return nil, errors.New(fmt.Sprintf("%s is not a file", filePath))
 	}
 
-	f, err := ioutil.ReadFile(filePath)
+	v, err, _ := c.sf.Do(alias, func() (interface{}, error) {
+		return ioutil.ReadFile(filePath)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	f := v.([]byte)
+	etag, gz := c.finalize(alias, f)
 
 	c.mu.Lock()
-	c.mapping[alias] = &Object{
+	c.deleteLocked(alias, nil)
+	evicted = c.evictUntilFits(int64(len(f)))
+	obj := &Object{
 		path:    filePath,
 		data:    f,
 		lastMod: info.ModTime(),
+		alias:   alias,
+		cache:   c,
+		etag:    etag,
+		gz:      gz,
 	}
+	c.mapping[alias] = obj
 	c.size += int64(len(f))
+	c.track(alias)
 	c.mu.Unlock()
 
-	return nil
+	atomic.AddInt64(&c.bytesIn, int64(len(f)))
+
+	if err := c.mirrorToDisk(obj); err != nil {
+		return evicted, err
+	}
+
+	return evicted, nil
+}
+
+// Put stores data in Cache under alias without any backing file. As
+// with AddFile, entries are evicted per the active Policy if
+// needed to keep the cache within MaxSize.
+func (c *Cache) Put(alias string, data []byte) (evicted []string) {
+
+	etag, gz := c.finalize(alias, data)
+
+	c.mu.Lock()
+	c.deleteLocked(alias, nil)
+	evicted = c.evictUntilFits(int64(len(data)))
+	obj := &Object{
+		data:    data,
+		lastMod: time.Now(),
+		alias:   alias,
+		cache:   c,
+		etag:    etag,
+		gz:      gz,
+	}
+	c.mapping[alias] = obj
+	c.size += int64(len(data))
+	c.track(alias)
+	c.mu.Unlock()
+
+	atomic.AddInt64(&c.bytesIn, int64(len(data)))
+
+	c.mirrorToDisk(obj)
+
+	return evicted
 }
 
 func (c *Cache) Delete(alias string) {
 	c.mu.Lock()
-	c.delete(alias, nil)
+	c.deleteLocked(alias, nil)
 	c.mu.Unlock()
 }
 
-func (c *Cache) delete(alias string, dropped []string) {
-	c.size -= int64(len(c.mapping[alias].data))
+func (c *Cache) delete(alias string, dropped []string) []string {
+	return c.deleteLocked(alias, dropped)
+}
+
+// deleteLocked removes alias, if present, and returns dropped with
+// alias appended when dropped is non-nil. Callers must hold c.mu
+// for writing.
+func (c *Cache) deleteLocked(alias string, dropped []string) []string {
+	obj, ok := c.mapping[alias]
+	if !ok {
+		return dropped
+	}
+	c.size -= int64(len(obj.data))
 	delete(c.mapping, alias)
+	c.untrack(alias)
 	if dropped != nil {
 		dropped = append(dropped, alias)
 	}
+	return dropped
 }
 
 func (c *Cache) Load(alias string) *Object {
@@ -178,52 +362,109 @@ func (c *Cache) Load(alias string) *Object {
 	f, ok := c.mapping[alias]
 	c.mu.RUnlock()
 	if !ok {
+		atomic.AddInt64(&c.misses, 1)
 		return nil
 	}
+	atomic.AddInt64(&c.hits, 1)
+	c.hydrate(f)
+	c.touch(alias)
+	atomic.AddInt64(&c.bytesOut, int64(len(c.state(f).data)))
 	return f
 }
 
 func (c *Cache) Empty() {
 	c.mu.Lock()
 	c.mapping = make(map[string]*Object)
+	c.size = 0
+	c.order = list.New()
+	c.elems = make(map[string]*list.Element)
+	c.pinned = make(map[string]bool)
 	c.mu.Unlock()
 }
 
+type refreshEntry struct {
+	alias   string
+	path    string
+	lastMod time.Time
+}
+
+// Refresh re-stats every file-backed Object and reloads those whose
+// mtime has advanced, dropping ones whose backing file has vanished
+// or is no longer a regular file. Unlike earlier versions, the
+// snapshot of what to check is taken under a read lock and all
+// stat/read I/O happens outside any lock, so Load is never blocked
+// for the duration of a scan; the write lock is only re-acquired
+// briefly to swap in the result, and is skipped if the Object was
+// concurrently deleted or re-added (detected via lastMod changing
+// out from under the snapshot).
 func (c *Cache) Refresh() (dropped []string) {
 
-	// Ensure dropped is non-nil for
-	// calls to c.delete
+	// Ensure dropped is non-nil for calls to deleteLocked.
 	dropped = []string{}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.mu.RLock()
+	snapshot := make([]refreshEntry, 0, len(c.mapping))
+	for alias, obj := range c.mapping {
+		if obj.path == "" {
+			continue
+		}
+		snapshot = append(snapshot, refreshEntry{
+			alias:   alias,
+			path:    obj.path,
+			lastMod: obj.lastMod,
+		})
+	}
+	c.mu.RUnlock()
 
-	for alias, file := range c.mapping {
+	for _, e := range snapshot {
 
-		info, err := os.Stat(file.path)
+		info, err := os.Stat(e.path)
 		if err != nil {
-			c.delete(alias, dropped)
+			dropped = c.dropIfUnchanged(e, dropped)
 			continue
 		}
 
 		if !info.Mode().IsRegular() {
-			c.delete(alias, dropped)
+			dropped = c.dropIfUnchanged(e, dropped)
 			continue
 		}
 
-		if !info.ModTime().After(file.lastMod) {
+		if !info.ModTime().After(e.lastMod) {
 			continue
 		}
 
-		f, err := ioutil.ReadFile(file.path)
+		v, err, _ := c.sf.Do(e.alias, func() (interface{}, error) {
+			return ioutil.ReadFile(e.path)
+		})
 		if err != nil {
-			c.delete(alias, dropped)
+			dropped = c.dropIfUnchanged(e, dropped)
 			continue
 		}
-
-		file.data = f
-		file.lastMod = info.ModTime()
+		f := v.([]byte)
+		etag, gz := c.finalize(e.alias, f)
+
+		c.mu.Lock()
+		if obj, ok := c.mapping[e.alias]; ok && obj.lastMod.Equal(e.lastMod) {
+			c.size += int64(len(f)) - int64(len(obj.data))
+			obj.data = f
+			obj.lastMod = info.ModTime()
+			obj.etag = etag
+			obj.gz = gz
+		}
+		c.mu.Unlock()
 	}
 
 	return dropped
 }
+
+// dropIfUnchanged deletes e.alias, appending it to dropped, unless
+// the Object has been concurrently deleted or replaced since e was
+// snapshotted (detected via lastMod no longer matching).
+func (c *Cache) dropIfUnchanged(e refreshEntry, dropped []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if obj, ok := c.mapping[e.alias]; ok && obj.lastMod.Equal(e.lastMod) {
+		dropped = c.deleteLocked(e.alias, dropped)
+	}
+	return dropped
+}
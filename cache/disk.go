@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DiskTier mirrors Cache's in-memory objects to a persistent
+// directory so that they survive process restarts and so that the
+// working set may exceed RAM. Objects are stored content-addressed,
+// Git-style: <Root>/ab/cdef... where the filename is the SHA-256 of
+// the content, alongside a sidecar <Root>/ab/cdef....meta file
+// holding the alias, original path and lastMod it was stored under.
+type DiskTier struct {
+	Root string
+
+	// MaxSize is the disk budget in bytes, independent of
+	// Cache.MaxSize. A MaxSize of 0 is treated as infinite.
+	MaxSize int64
+}
+
+type diskMeta struct {
+	Alias   string    `json:"alias"`
+	Path    string    `json:"path"`
+	LastMod time.Time `json:"lastMod"`
+}
+
+// NewWithDisk creates a Cache backed by a DiskTier rooted at root.
+// If root already holds entries from a previous process, its index
+// is rebuilt from the sidecar .meta files without reading any blob
+// bodies -- data is hydrated lazily, the first time Load is called
+// for that alias. Old blobs are evicted, per access time, if root
+// already exceeds maxSize.
+func NewWithDisk(root string, maxSize int64) (*Cache, error) {
+	c := New()
+	c.disk = &DiskTier{Root: root, MaxSize: maxSize}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	if err := c.rebuildDiskIndex(); err != nil {
+		return nil, err
+	}
+	if err := c.evictDiskTier(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func blobPath(root, hash string) string {
+	return filepath.Join(root, hash[:2], hash[2:])
+}
+
+func metaPath(root, hash string) string {
+	return blobPath(root, hash) + ".meta"
+}
+
+// mirrorToDisk writes obj's data and sidecar metadata to the disk
+// tier, recording the content hash on obj so Load can hydrate from
+// it later. It is a no-op if no DiskTier is configured.
+func (c *Cache) mirrorToDisk(obj *Object) error {
+
+	if c.disk == nil {
+		return nil
+	}
+
+	sum := sha256.Sum256(obj.data)
+	hash := hex.EncodeToString(sum[:])
+
+	blob := blobPath(c.disk.Root, hash)
+	if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(blob); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(blob, obj.data, 0644); err != nil {
+			return err
+		}
+	}
+
+	meta := diskMeta{Alias: obj.alias, Path: obj.path, LastMod: obj.lastMod}
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(metaPath(c.disk.Root, hash), b, 0644); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	obj.diskHash = hash
+	c.mu.Unlock()
+
+	return c.evictDiskTier()
+}
+
+// rebuildDiskIndex walks c.disk.Root and populates c.mapping from
+// the .meta sidecar files it finds, without reading any blob
+// bodies.
+func (c *Cache) rebuildDiskIndex() error {
+	return filepath.Walk(c.disk.Root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, ".meta") {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		var m diskMeta
+		if err := json.Unmarshal(b, &m); err != nil {
+			return err
+		}
+
+		hash := filepath.Base(info.Name())
+		hash = strings.TrimSuffix(hash, ".meta")
+		hash = filepath.Base(filepath.Dir(p)) + hash
+
+		c.mu.Lock()
+		c.mapping[m.Alias] = &Object{
+			path:     m.Path,
+			lastMod:  m.LastMod,
+			alias:    m.Alias,
+			cache:    c,
+			diskHash: hash,
+		}
+		c.track(m.Alias)
+		c.mu.Unlock()
+
+		return nil
+	})
+}
+
+// hydrate reads obj's data from the disk tier if it has not already
+// been loaded into memory. It is a no-op for objects with no
+// backing blob (diskHash == "") or once obj.data is already set.
+func (c *Cache) hydrate(obj *Object) error {
+
+	if c.disk == nil || obj.diskHash == "" {
+		return nil
+	}
+
+	c.mu.RLock()
+	loaded := obj.data != nil
+	c.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(blobPath(c.disk.Root, obj.diskHash))
+	if err != nil {
+		return err
+	}
+	etag, gz := c.finalize(obj.alias, data)
+
+	c.mu.Lock()
+	if obj.data == nil {
+		obj.data = data
+		c.size += int64(len(data))
+		obj.etag = etag
+		obj.gz = gz
+	}
+	c.mu.Unlock()
+
+	atomic.AddInt64(&c.bytesIn, int64(len(data)))
+
+	return nil
+}
+
+type diskBlob struct {
+	hash     string
+	size     int64
+	accessed time.Time
+}
+
+// evictDiskTier removes the least-recently-accessed blobs (oldest
+// mtime if atime is unavailable) from the disk tier until it fits
+// within DiskTier.MaxSize. Any alias in c.mapping that depended
+// solely on a removed blob (i.e. it has not yet been hydrated into
+// memory) is removed too, so it doesn't linger as an alias that can
+// never load.
+func (c *Cache) evictDiskTier() error {
+
+	if c.disk == nil || c.disk.MaxSize <= 0 {
+		return nil
+	}
+
+	var blobs []diskBlob
+	var total int64
+
+	err := filepath.Walk(c.disk.Root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(p, ".meta") {
+			return nil
+		}
+		total += info.Size()
+		blobs = append(blobs, diskBlob{
+			hash:     filepath.Base(filepath.Dir(p)) + filepath.Base(p),
+			size:     info.Size(),
+			accessed: accessTime(info),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if total <= c.disk.MaxSize {
+		return nil
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].accessed.Before(blobs[j].accessed)
+	})
+
+	for _, b := range blobs {
+		if total <= c.disk.MaxSize {
+			break
+		}
+		os.Remove(blobPath(c.disk.Root, b.hash))
+		os.Remove(metaPath(c.disk.Root, b.hash))
+		total -= b.size
+		c.orphanUnhydrated(b.hash)
+	}
+
+	return nil
+}
+
+// orphanUnhydrated removes any alias whose only copy of its data
+// was the disk blob identified by hash and which has not yet been
+// hydrated into memory, since that blob no longer exists to hydrate
+// from.
+func (c *Cache) orphanUnhydrated(hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for alias, obj := range c.mapping {
+		if obj.diskHash == hash && obj.data == nil {
+			c.deleteLocked(alias, nil)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMirrorToDiskWritesContentAddressedLayout(t *testing.T) {
+
+	root := t.TempDir()
+	c, err := NewWithDisk(root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Put("a", []byte("hello"))
+	obj := c.Load("a")
+
+	hash := obj.diskHash
+	if hash == "" {
+		t.Fatal("obj.diskHash was not set by mirrorToDisk")
+	}
+
+	blob := blobPath(root, hash)
+	if _, err := os.Stat(filepath.Dir(blob)); err != nil {
+		t.Fatalf("blob's fanout directory %q missing: %v", filepath.Dir(blob), err)
+	}
+	if filepath.Dir(blob) != filepath.Join(root, hash[:2]) {
+		t.Fatalf("got blob dir %q, want fanout on hash[:2] %q", filepath.Dir(blob), hash[:2])
+	}
+
+	got, err := os.ReadFile(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("got blob content %q, want %q", got, "hello")
+	}
+
+	metaBytes, err := os.ReadFile(metaPath(root, hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m diskMeta
+	if err := json.Unmarshal(metaBytes, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Alias != "a" {
+		t.Fatalf("got meta alias %q, want %q", m.Alias, "a")
+	}
+}
+
+func TestNewWithDiskRebuildsIndexAndLazilyHydrates(t *testing.T) {
+
+	root := t.TempDir()
+
+	c1, err := NewWithDisk(root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1.Put("a", []byte("hello"))
+
+	// A fresh Cache opened on the same root should pick "a" up from
+	// the sidecar .meta files alone, with obj.data filled in lazily
+	// on the first Load rather than eagerly at open time.
+	c2, err := NewWithDisk(root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c2.mu.RLock()
+	obj, ok := c2.mapping["a"]
+	c2.mu.RUnlock()
+	if !ok {
+		t.Fatal("rebuildDiskIndex did not register alias \"a\"")
+	}
+	if obj.data != nil {
+		t.Fatal("rebuildDiskIndex should not have read the blob body eagerly")
+	}
+
+	got := c2.Load("a")
+	if got == nil {
+		t.Fatal("\"a\" should be loadable after rebuild")
+	}
+	if string(got.data) != "hello" {
+		t.Fatalf("got data %q, want %q", got.data, "hello")
+	}
+}
+
+func TestEvictDiskTierRemovesLeastRecentlyAccessed(t *testing.T) {
+
+	root := t.TempDir()
+
+	// Budget enough for two of these ~5-byte blobs but not three.
+	c, err := NewWithDisk(root, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Put("a", []byte("aaaaa"))
+	time.Sleep(10 * time.Millisecond)
+	c.Put("b", []byte("bbbbb"))
+	time.Sleep(10 * time.Millisecond)
+
+	aObj := c.Load("a")
+	bObj := c.Load("b")
+
+	// Make "a"'s blob look least-recently-accessed regardless of
+	// write order, since eviction ranks by atime.
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(blobPath(root, aObj.diskHash), past, past); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := os.Chtimes(blobPath(root, bObj.diskHash), now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding "c" pushes total past MaxSize, forcing an eviction pass.
+	c.Put("c", []byte("ccccc"))
+
+	if _, err := os.Stat(blobPath(root, aObj.diskHash)); !os.IsNotExist(err) {
+		t.Fatalf("blob for \"a\" should have been evicted, stat err: %v", err)
+	}
+	if _, err := os.Stat(blobPath(root, bObj.diskHash)); err != nil {
+		t.Fatalf("blob for \"b\" should still exist: %v", err)
+	}
+}
+
+func TestOrphanUnhydratedDropsUnreachableAlias(t *testing.T) {
+
+	root := t.TempDir()
+
+	c1, err := NewWithDisk(root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1.Put("a", []byte("hello"))
+	obj := c1.Load("a")
+	hash := obj.diskHash
+
+	// Reopen without hydrating "a", so its only copy of the data is
+	// the disk blob, then simulate that blob being evicted.
+	c2, err := NewWithDisk(root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c2.orphanUnhydrated(hash)
+
+	if c2.Load("a") != nil {
+		t.Fatal("alias whose only blob was evicted should have been dropped")
+	}
+}
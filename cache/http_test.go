@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandlerServesDataAndContentType(t *testing.T) {
+
+	c := New()
+	c.Put("style.css", []byte("body{}"))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/assets/style.css", nil)
+	c.Handler("/assets/").ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Body.String(); got != "body{}" {
+		t.Fatalf("got body %q, want %q", got, "body{}")
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/css; charset=utf-8" {
+		t.Fatalf("got Content-Type %q, want text/css", ct)
+	}
+}
+
+func TestHandler404sMissingAlias(t *testing.T) {
+
+	c := New()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/assets/missing.css", nil)
+	c.Handler("/assets/").ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandlerReturns304ForMatchingETag(t *testing.T) {
+
+	c := New()
+	c.Put("style.css", []byte("body{}"))
+
+	etag := httptest.NewRecorder()
+	c.Handler("/assets/").ServeHTTP(etag, httptest.NewRequest(http.MethodGet, "/assets/style.css", nil))
+	want := etag.Header().Get("ETag")
+	if want == "" {
+		t.Fatal("Handler did not set an ETag")
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/assets/style.css", nil)
+	req.Header.Set("If-None-Match", want)
+	c.Handler("/assets/").ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusNotModified)
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("304 response should have no body, got %q", rec.Body.String())
+	}
+}
+
+func TestHandlerReturns304ForFreshIfModifiedSince(t *testing.T) {
+
+	c := New()
+	c.Put("style.css", []byte("body{}"))
+
+	future := time.Now().Add(time.Hour)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/assets/style.css", nil)
+	req.Header.Set("If-Modified-Since", future.UTC().Format(http.TimeFormat))
+	c.Handler("/assets/").ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusNotModified)
+	}
+}
+
+func TestHandlerServesGzipWhenPrecompressedAndAccepted(t *testing.T) {
+
+	c := New()
+	c.Precompress = []string{".css"}
+	c.Put("style.css", []byte("body{}"))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/assets/style.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	c.Handler("/assets/").ServeHTTP(rec, req)
+
+	if enc := rec.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("got Content-Encoding %q, want gzip", enc)
+	}
+	if rec.Body.String() == "body{}" {
+		t.Fatal("body was not gzip-compressed")
+	}
+}
+
+func TestHandlerServesPlainBodyWhenGzipNotAccepted(t *testing.T) {
+
+	c := New()
+	c.Precompress = []string{".css"}
+	c.Put("style.css", []byte("body{}"))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/assets/style.css", nil)
+	c.Handler("/assets/").ServeHTTP(rec, req)
+
+	if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+		t.Fatalf("got Content-Encoding %q, want none", enc)
+	}
+	if got := rec.Body.String(); got != "body{}" {
+		t.Fatalf("got body %q, want %q", got, "body{}")
+	}
+}
+
+// TestHandlerConcurrentWithRefresh exercises Handler and Refresh from
+// separate goroutines under the race detector, guarding against the
+// unsynchronized reads of obj.data/etag/lastMod/gz that Handler used
+// to perform directly.
+func TestHandlerConcurrentWithRefresh(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "style.css")
+	if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New()
+	c.Precompress = []string{".css"}
+	c.MustAddFile("style.css", path)
+
+	h := c.Handler("/assets/")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/assets/style.css", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			h.ServeHTTP(rec, req)
+		}
+	}()
+
+	if err := os.WriteFile(path, []byte("v2-longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	c.Refresh()
+
+	<-done
+}
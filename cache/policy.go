@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"sync/atomic"
+)
+
+// Policy selects how Cache chooses entries to evict once MaxSize
+// would otherwise be exceeded.
+type Policy int
+
+const (
+	// LRU evicts the least-recently-used entry first. This is
+	// the default policy.
+	LRU Policy = iota
+
+	// LFU evicts the least-frequently-used entry first, breaking
+	// ties arbitrarily.
+	LFU
+)
+
+// SetPolicy changes the eviction policy used when Cache must make
+// room for new data. It does not retroactively reorder existing
+// entries.
+func (c *Cache) SetPolicy(p Policy) {
+	c.mu.Lock()
+	c.policy = p
+	c.mu.Unlock()
+}
+
+// Stats holds cumulative counters describing Cache activity since
+// it was created.
+type Stats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	BytesIn   int64
+	BytesOut  int64
+}
+
+// Stats returns a snapshot of Cache's cumulative counters.
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:      atomic.LoadInt64(&c.hits),
+		Misses:    atomic.LoadInt64(&c.misses),
+		Evictions: atomic.LoadInt64(&c.evictions),
+		BytesIn:   atomic.LoadInt64(&c.bytesIn),
+		BytesOut:  atomic.LoadInt64(&c.bytesOut),
+	}
+}
+
+// Pin prevents alias from being evicted until Unpin is called. Pin
+// is a no-op if alias is not present in Cache.
+func (c *Cache) Pin(alias string) {
+	c.mu.Lock()
+	if _, ok := c.mapping[alias]; ok {
+		c.pinned[alias] = true
+	}
+	c.mu.Unlock()
+}
+
+// Unpin allows alias to be evicted again.
+func (c *Cache) Unpin(alias string) {
+	c.mu.Lock()
+	delete(c.pinned, alias)
+	c.mu.Unlock()
+}
+
+// touch records an access against alias, bumping it to the front of
+// the recency list and incrementing its use count. Callers must not
+// be holding c.mu.
+func (c *Cache) touch(alias string) {
+	c.mu.Lock()
+	c.touchLocked(alias)
+	c.mu.Unlock()
+}
+
+func (c *Cache) touchLocked(alias string) {
+	if e, ok := c.elems[alias]; ok {
+		c.order.MoveToFront(e)
+	}
+	if obj, ok := c.mapping[alias]; ok {
+		obj.uses++
+	}
+}
+
+// track registers alias in the recency list. Callers must hold
+// c.mu for writing.
+func (c *Cache) track(alias string) {
+	if e, ok := c.elems[alias]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.elems[alias] = c.order.PushFront(alias)
+}
+
+// untrack removes alias from the recency list and pin set. Callers
+// must hold c.mu for writing.
+func (c *Cache) untrack(alias string) {
+	if e, ok := c.elems[alias]; ok {
+		c.order.Remove(e)
+		delete(c.elems, alias)
+	}
+	delete(c.pinned, alias)
+}
+
+// evictUntilFits evicts entries, per the active Policy, until
+// adding additional bytes would no longer exceed MaxSize. Pinned
+// entries are never evicted. Callers must hold c.mu for writing.
+func (c *Cache) evictUntilFits(additional int64) (evicted []string) {
+	for c.MaxSize > 0 && c.size+additional > c.MaxSize {
+		alias, ok := c.evictOneLocked()
+		if !ok {
+			break
+		}
+		evicted = append(evicted, alias)
+	}
+	return evicted
+}
+
+func (c *Cache) evictOneLocked() (alias string, ok bool) {
+	switch c.policy {
+	case LFU:
+		alias, ok = c.lfuCandidateLocked()
+	default:
+		alias, ok = c.lruCandidateLocked()
+	}
+	if !ok {
+		return "", false
+	}
+	c.deleteLocked(alias, nil)
+	atomic.AddInt64(&c.evictions, 1)
+	return alias, true
+}
+
+func (c *Cache) lruCandidateLocked() (string, bool) {
+	for e := c.order.Back(); e != nil; e = e.Prev() {
+		alias := e.Value.(string)
+		if c.pinned[alias] {
+			continue
+		}
+		return alias, true
+	}
+	return "", false
+}
+
+func (c *Cache) lfuCandidateLocked() (string, bool) {
+	var alias string
+	var min int64 = -1
+	for a, obj := range c.mapping {
+		if c.pinned[a] {
+			continue
+		}
+		if min == -1 || obj.uses < min {
+			min = obj.uses
+			alias = a
+		}
+	}
+	if min == -1 {
+		return "", false
+	}
+	return alias, true
+}
@@ -0,0 +1,92 @@
+package cache
+
+import "testing"
+
+func TestInPlaceReloadDoesNotEvictUnrelatedEntries(t *testing.T) {
+
+	c := New()
+	c.MaxSize = 100
+
+	c.Put("a", make([]byte, 90))
+	c.Put("b", make([]byte, 5))
+
+	// Touch "a" so "b" becomes the least-recently-used entry.
+	c.Load("a")
+
+	// 95 - 90 + 91 = 96 <= 100: replacing "a" in place should need
+	// no eviction at all, and must not evict the unrelated "b".
+	evicted := c.Put("a", make([]byte, 91))
+
+	if len(evicted) != 0 {
+		t.Fatalf("got evicted %v, want none", evicted)
+	}
+	if c.Load("b") == nil {
+		t.Fatal("unrelated entry \"b\" was evicted")
+	}
+	if c.Load("a") == nil {
+		t.Fatal("entry \"a\" was evicted while replacing itself")
+	}
+}
+
+func TestEvictUntilFitsRemovesLeastRecentlyUsed(t *testing.T) {
+
+	c := New()
+	c.MaxSize = 100
+
+	c.Put("a", make([]byte, 50))
+	c.Put("b", make([]byte, 40))
+	c.Load("a") // "b" is now the LRU entry.
+
+	evicted := c.Put("c", make([]byte, 30))
+
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Fatalf("got evicted %v, want [b]", evicted)
+	}
+	if c.Load("b") != nil {
+		t.Fatal("\"b\" should have been evicted")
+	}
+	if c.Load("a") == nil || c.Load("c") == nil {
+		t.Fatal("\"a\" and \"c\" should both still be present")
+	}
+}
+
+func TestPinProtectsFromEviction(t *testing.T) {
+
+	c := New()
+	c.MaxSize = 100
+
+	c.Put("a", make([]byte, 50))
+	c.Pin("a")
+	c.Put("b", make([]byte, 40))
+	c.Load("b") // "a" is pinned and would otherwise be the LRU entry.
+
+	evicted := c.Put("c", make([]byte, 30))
+
+	if in(evicted, "a") {
+		t.Fatalf("pinned entry \"a\" was evicted: %v", evicted)
+	}
+	if c.Load("a") == nil {
+		t.Fatal("pinned entry \"a\" should still be present")
+	}
+}
+
+func TestLFUPolicyEvictsLeastFrequentlyUsed(t *testing.T) {
+
+	c := New()
+	c.MaxSize = 100
+	c.SetPolicy(LFU)
+
+	c.Put("a", make([]byte, 50))
+	c.Put("b", make([]byte, 40))
+
+	for i := 0; i < 3; i++ {
+		c.Load("a")
+	}
+	c.Load("b")
+
+	evicted := c.Put("c", make([]byte, 30))
+
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Fatalf("got evicted %v, want [b]", evicted)
+	}
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRefreshReloadsChangedFile(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f.txt")
+	if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New()
+	c.MustAddFile("f", path)
+
+	if err := os.WriteFile(path, []byte("v2-longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	dropped := c.Refresh()
+	if len(dropped) != 0 {
+		t.Fatalf("got dropped %v, want none", dropped)
+	}
+
+	obj := c.Load("f")
+	if obj == nil {
+		t.Fatal("\"f\" should still be present")
+	}
+	if string(obj.data) != "v2-longer" {
+		t.Fatalf("got data %q, want %q", obj.data, "v2-longer")
+	}
+}
+
+func TestRefreshDropsVanishedFile(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f.txt")
+	if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New()
+	c.MustAddFile("f", path)
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	dropped := c.Refresh()
+	if len(dropped) != 1 || dropped[0] != "f" {
+		t.Fatalf("got dropped %v, want [f]", dropped)
+	}
+	if c.Load("f") != nil {
+		t.Fatal("\"f\" should have been dropped")
+	}
+}
+
+// TestDropIfUnchangedDeletesWhenStillMatching covers the common
+// path: the Object snapshotted by Refresh is still the same one in
+// c.mapping, so it's safe to delete.
+func TestDropIfUnchangedDeletesWhenStillMatching(t *testing.T) {
+
+	c := New()
+	c.Put("a", []byte("v1"))
+	obj := c.Load("a")
+
+	e := refreshEntry{alias: "a", path: obj.path, lastMod: obj.lastMod}
+	dropped := c.dropIfUnchanged(e, []string{})
+
+	if len(dropped) != 1 || dropped[0] != "a" {
+		t.Fatalf("got dropped %v, want [a]", dropped)
+	}
+	if c.Load("a") != nil {
+		t.Fatal("\"a\" should have been deleted")
+	}
+}
+
+// TestDropIfUnchangedSkipsConcurrentlyReplaced covers Refresh's
+// guard against racing a concurrent AddFile/Put for the same alias:
+// if the Object's lastMod no longer matches what was snapshotted,
+// the delete must be skipped rather than clobbering the new entry.
+func TestDropIfUnchangedSkipsConcurrentlyReplaced(t *testing.T) {
+
+	c := New()
+	c.Put("a", []byte("v1"))
+	obj := c.Load("a")
+	stale := refreshEntry{alias: "a", path: obj.path, lastMod: obj.lastMod}
+
+	// Simulate a concurrent replace that lands after the snapshot
+	// was taken but before Refresh acts on it.
+	c.Put("a", []byte("v2-longer"))
+
+	dropped := c.dropIfUnchanged(stale, nil)
+	if len(dropped) != 0 {
+		t.Fatalf("got dropped %v, want none", dropped)
+	}
+
+	got := c.Load("a")
+	if got == nil {
+		t.Fatal("concurrently replaced \"a\" should not have been deleted")
+	}
+	if string(got.data) != "v2-longer" {
+		t.Fatalf("got data %q, want %q", got.data, "v2-longer")
+	}
+}
+
+// TestRefreshConcurrentWithLoad exercises Refresh's snapshot-then-
+// swap design alongside concurrent Load calls under the race
+// detector; it asserts only that nothing panics or deadlocks.
+func TestRefreshConcurrentWithLoad(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f.txt")
+	if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New()
+	c.MustAddFile("f", path)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.Load("f")
+		}
+	}()
+
+	if err := os.WriteFile(path, []byte("v2-longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Refresh()
+	<-done
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Decoder turns the raw bytes held for an alias into a value of
+// type T.
+type Decoder[T any] func([]byte) (T, error)
+
+type typedEntry[T any] struct {
+	value   T
+	lastMod time.Time
+}
+
+// TypedCache layers a decoded, type-safe view over a Cache. Decoded
+// values are cached alongside the raw bytes and re-decoded only
+// when the underlying Object's LastMod advances (e.g. after
+// Refresh picks up a changed file), so callers pay the decode cost
+// once per change rather than once per request. This replaces the
+// ad-hoc Object.Bytes/Object.CSS accessors with a single type-safe
+// API: construct a TypedCache[[]byte] or TypedCache[template.CSS]
+// with RawDecoder/CSSDecoder for the equivalent behaviour.
+type TypedCache[T any] struct {
+	cache  *Cache
+	decode Decoder[T]
+
+	mu      sync.RWMutex
+	decoded map[string]typedEntry[T]
+}
+
+// NewTyped wraps cache, decoding each Object's bytes with decode.
+func NewTyped[T any](cache *Cache, decode Decoder[T]) *TypedCache[T] {
+	return &TypedCache[T]{
+		cache:   cache,
+		decode:  decode,
+		decoded: make(map[string]typedEntry[T]),
+	}
+}
+
+// Load returns the decoded value stored under alias, decoding it
+// (and caching the result) if it hasn't been decoded yet or if the
+// underlying Object has changed since the last decode.
+func (tc *TypedCache[T]) Load(alias string) (T, error) {
+
+	var zero T
+
+	obj := tc.cache.Load(alias)
+	if obj == nil {
+		return zero, fmt.Errorf("cache: no object for alias %q", alias)
+	}
+	st := tc.cache.state(obj)
+
+	tc.mu.RLock()
+	entry, ok := tc.decoded[alias]
+	tc.mu.RUnlock()
+	if ok && !st.lastMod.After(entry.lastMod) {
+		return entry.value, nil
+	}
+
+	v, err := tc.decode(st.data)
+	if err != nil {
+		return zero, err
+	}
+
+	tc.mu.Lock()
+	tc.decoded[alias] = typedEntry[T]{value: v, lastMod: st.lastMod}
+	tc.mu.Unlock()
+
+	return v, nil
+}
+
+// RawDecoder returns the bytes held for an alias unchanged.
+func RawDecoder(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// CSSDecoder decodes bytes as pre-sanitised CSS, matching the
+// behaviour of the old Object.CSS accessor.
+func CSSDecoder(data []byte) (template.CSS, error) {
+	return template.CSS(data), nil
+}
+
+// HTMLDecoder decodes bytes as pre-sanitised HTML.
+func HTMLDecoder(data []byte) (template.HTML, error) {
+	return template.HTML(data), nil
+}
+
+// TemplateDecoder returns a Decoder that parses an Object's bytes
+// as the root template, then parses every file in dir as an
+// associated template (glob "*"), so named templates defined
+// alongside the root (layouts, partials) are available via
+// ExecuteTemplate. dir may be empty to skip associated templates.
+func TemplateDecoder(name, dir string, funcs template.FuncMap) Decoder[*template.Template] {
+	return func(data []byte) (*template.Template, error) {
+
+		t, err := template.New(name).Funcs(funcs).Parse(string(data))
+		if err != nil {
+			return nil, err
+		}
+
+		if dir == "" {
+			return t, nil
+		}
+
+		t, err = t.ParseGlob(filepath.Join(dir, "*"))
+		if err != nil {
+			return nil, err
+		}
+
+		return t, nil
+	}
+}
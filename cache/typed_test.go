@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTypedCacheDecodesOnce(t *testing.T) {
+
+	var decodes int
+	decoder := func(data []byte) (string, error) {
+		decodes++
+		return string(data), nil
+	}
+
+	c := New()
+	c.Put("a", []byte("v1"))
+	tc := NewTyped(c, decoder)
+
+	v, err := tc.Load("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "v1" {
+		t.Fatalf("got %q, want %q", v, "v1")
+	}
+
+	if _, err := tc.Load("a"); err != nil {
+		t.Fatal(err)
+	}
+	if decodes != 1 {
+		t.Fatalf("got %d decodes, want 1 (second Load should reuse cached value)", decodes)
+	}
+}
+
+func TestTypedCacheRedecodesAfterLastModAdvances(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f.txt")
+	if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var decodes int
+	decoder := func(data []byte) (string, error) {
+		decodes++
+		return string(data), nil
+	}
+
+	c := New()
+	c.MustAddFile("f", path)
+	tc := NewTyped(c, decoder)
+
+	if _, err := tc.Load("f"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("v2-longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	c.Refresh()
+
+	v, err := tc.Load("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "v2-longer" {
+		t.Fatalf("got %q, want %q", v, "v2-longer")
+	}
+	if decodes != 2 {
+		t.Fatalf("got %d decodes, want 2 (Refresh advancing LastMod should trigger a re-decode)", decodes)
+	}
+}
+
+func TestTypedCacheLoadErrorsOnMissingAlias(t *testing.T) {
+
+	c := New()
+	tc := NewTyped(c, RawDecoder)
+
+	_, err := tc.Load("missing")
+	if err == nil {
+		t.Fatal("expected an error for a missing alias")
+	}
+}
+
+func TestTypedCacheZeroValueOnDecodeError(t *testing.T) {
+
+	decodeErr := errors.New("bad data")
+	decoder := func(data []byte) (int, error) {
+		return 0, decodeErr
+	}
+
+	c := New()
+	c.Put("a", []byte("not an int"))
+	tc := NewTyped(c, decoder)
+
+	v, err := tc.Load("a")
+	if !errors.Is(err, decodeErr) {
+		t.Fatalf("got err %v, want %v", err, decodeErr)
+	}
+	if v != 0 {
+		t.Fatalf("got %d, want zero value on error", v)
+	}
+}
+
+func TestTemplateDecoderParsesAssociatedTemplates(t *testing.T) {
+
+	dir := t.TempDir()
+	partial := filepath.Join(dir, "partial.tmpl")
+	if err := os.WriteFile(partial, []byte(`{{define "partial"}}hi{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New()
+	c.Put("root", []byte(`{{template "partial"}}`))
+	tc := NewTyped(c, TemplateDecoder("root", dir, nil))
+
+	tmpl, err := tc.Load("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hi" {
+		t.Fatalf("got %q, want %q", buf.String(), "hi")
+	}
+}
+
+func TestTemplateDecoderSkipsAssociatedTemplatesWhenDirEmpty(t *testing.T) {
+
+	c := New()
+	c.Put("root", []byte("hello"))
+	tc := NewTyped(c, TemplateDecoder("root", "", nil))
+
+	tmpl, err := tc.Load("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hello" {
+		t.Fatalf("got %q, want %q", buf.String(), "hello")
+	}
+}
@@ -0,0 +1,302 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// dirWatch records the parameters an AddDir call was made with so
+// Watch can deterministically re-scan the tree it covers.
+type dirWatch struct {
+	alias     string
+	dirPath   string
+	exts      []string
+	recursive bool
+}
+
+// fileWatch records the absolute path of a file added via AddFile
+// so Watch can watch its parent directory and react to it changing
+// or disappearing.
+type fileWatch struct {
+	alias    string
+	filePath string
+}
+
+// EventOp describes what happened to an alias during Watch.
+type EventOp int
+
+const (
+	Added EventOp = iota
+	Modified
+	Deleted
+)
+
+// Event is sent on the channel returned by Watch whenever Watch
+// adds, reloads or removes an alias in response to a filesystem
+// change.
+type Event struct {
+	Alias string
+	Op    EventOp
+	Err   error
+}
+
+const watchDebounce = 100 * time.Millisecond
+
+// debouncer coalesces rapid, repeated triggers for the same key into
+// a single call, made once no trigger for that key has arrived for
+// window.
+type debouncer struct {
+	mu     sync.Mutex
+	window time.Duration
+	timers map[string]*time.Timer
+}
+
+func newDebouncer(window time.Duration) *debouncer {
+	return &debouncer{
+		window: window,
+		timers: make(map[string]*time.Timer),
+	}
+}
+
+// trigger schedules fn to run after window, resetting the window if
+// key was already pending.
+func (d *debouncer) trigger(key string, fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t, ok := d.timers[key]; ok {
+		t.Stop()
+	}
+	d.timers[key] = time.AfterFunc(d.window, func() {
+		d.mu.Lock()
+		delete(d.timers, key)
+		d.mu.Unlock()
+		fn()
+	})
+}
+
+// Watch installs filesystem watchers for every directory registered
+// via AddDir (and, where AddDir was called with recursive, every
+// subdirectory beneath it), and the parent directory of every file
+// registered via AddFile, then reflects changes underneath them into
+// Cache: changed files are reloaded in place, vanished files are
+// deleted, and new files matching the originating AddDir's exts
+// filter are added. Rapid successive writes to the same path are
+// coalesced with a short debounce window. Call StopWatch, or cancel
+// ctx, to tear the watcher down.
+func (c *Cache) Watch(ctx context.Context) (<-chan Event, error) {
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	c.watchMu.Lock()
+	dirs := make([]dirWatch, 0, len(c.dirWatches))
+	for _, d := range c.dirWatches {
+		dirs = append(dirs, d)
+	}
+	files := make([]fileWatch, 0, len(c.fileWatches))
+	for _, f := range c.fileWatches {
+		files = append(files, f)
+	}
+	c.watchMu.Unlock()
+
+	watched := make(map[string]bool)
+	watchDir := func(p string) error {
+		if watched[p] {
+			return nil
+		}
+		if err := w.Add(p); err != nil {
+			return err
+		}
+		watched[p] = true
+		return nil
+	}
+
+	for _, d := range dirs {
+		if !d.recursive {
+			if err := watchDir(d.dirPath); err != nil {
+				w.Close()
+				return nil, err
+			}
+			continue
+		}
+		err := filepath.Walk(d.dirPath, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return watchDir(p)
+			}
+			return nil
+		})
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	for _, f := range files {
+		if err := watchDir(filepath.Dir(f.filePath)); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	c.watchMu.Lock()
+	c.watcher = w
+	c.watchCancel = cancel
+	c.watchMu.Unlock()
+
+	events := make(chan Event)
+
+	go c.watchLoop(ctx, w, events, dirs, files, watchDir)
+
+	return events, nil
+}
+
+// StopWatch tears down the watcher started by Watch, if any,
+// closing the channel Watch returned.
+func (c *Cache) StopWatch() {
+	c.watchMu.Lock()
+	cancel := c.watchCancel
+	c.watcher = nil
+	c.watchCancel = nil
+	c.watchMu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (c *Cache) watchLoop(
+	ctx context.Context,
+	w *fsnotify.Watcher,
+	events chan<- Event,
+	dirs []dirWatch,
+	files []fileWatch,
+	watchDir func(string) error,
+) {
+	defer close(events)
+	defer w.Close()
+
+	db := newDebouncer(watchDebounce)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			p := ev.Name
+			db.trigger(p, func() {
+				c.handleWatchEvent(p, ev.Op, dirs, files, watchDir, events)
+			})
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			events <- Event{Err: err}
+		}
+	}
+}
+
+func (c *Cache) handleWatchEvent(
+	p string,
+	op fsnotify.Op,
+	dirs []dirWatch,
+	files []fileWatch,
+	watchDir func(string) error,
+	events chan<- Event,
+) {
+
+	for _, f := range files {
+		if f.filePath != p {
+			continue
+		}
+		c.reloadOrDrop(f.alias, p, events)
+		return
+	}
+
+	for _, d := range dirs {
+
+		if !underDir(p, d.dirPath) {
+			continue
+		}
+
+		info, err := os.Stat(p)
+		if err != nil {
+			alias := aliasFor(d, p)
+			c.Delete(alias)
+			events <- Event{Alias: alias, Op: Deleted}
+			return
+		}
+
+		if info.IsDir() {
+			if d.recursive && op&(fsnotify.Create) != 0 {
+				watchDir(p)
+			}
+			return
+		}
+
+		ext := filepath.Ext(p)
+		if len(d.exts) > 0 && !in(d.exts, ext) {
+			return
+		}
+
+		alias := aliasFor(d, p)
+		added := c.Load(alias) == nil
+		if _, err := c.addFile(alias, p); err != nil {
+			events <- Event{Alias: alias, Err: err}
+			return
+		}
+		if added {
+			events <- Event{Alias: alias, Op: Added}
+		} else {
+			events <- Event{Alias: alias, Op: Modified}
+		}
+		return
+	}
+}
+
+// underDir reports whether p is root itself or a descendant of it.
+// A raw strings.HasPrefix would also match unrelated siblings that
+// merely share a string prefix (e.g. "/assets" vs "/assets-backup").
+func underDir(p, root string) bool {
+	return p == root || strings.HasPrefix(p, root+string(filepath.Separator))
+}
+
+// aliasFor derives the alias a path within d's tree would have been
+// given by AddDir.
+func aliasFor(d dirWatch, p string) string {
+	rel := strings.TrimPrefix(p, d.dirPath)
+	rel = filepath.ToSlash(rel)
+	return d.alias + rel
+}
+
+func (c *Cache) reloadOrDrop(alias, path string, events chan<- Event) {
+
+	if _, err := os.Stat(path); err != nil {
+		c.Delete(alias)
+		events <- Event{Alias: alias, Op: Deleted}
+		return
+	}
+
+	if _, err := c.addFile(alias, path); err != nil {
+		events <- Event{Alias: alias, Err: err}
+		return
+	}
+
+	events <- Event{Alias: alias, Op: Modified}
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDebouncerCoalescesRapidTriggers(t *testing.T) {
+	d := newDebouncer(20 * time.Millisecond)
+
+	var calls int32
+	for i := 0; i < 5; i++ {
+		d.trigger("a", func() { atomic.AddInt32(&calls, 1) })
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("got %d calls, want 1 (rapid triggers should coalesce)", got)
+	}
+}
+
+func TestDebouncerTracksKeysIndependently(t *testing.T) {
+	d := newDebouncer(10 * time.Millisecond)
+
+	var a, b int32
+	d.trigger("a", func() { atomic.AddInt32(&a, 1) })
+	d.trigger("b", func() { atomic.AddInt32(&b, 1) })
+
+	time.Sleep(50 * time.Millisecond)
+
+	if atomic.LoadInt32(&a) != 1 || atomic.LoadInt32(&b) != 1 {
+		t.Fatalf("got a=%d b=%d, want both 1", a, b)
+	}
+}
+
+// TestWatchRecursiveAddsFilesInNewSubdir covers a dirWatch with
+// recursive=true: a file created inside a brand-new subdirectory
+// should be picked up, since the new subdirectory itself gets
+// watched on its Create event.
+func TestWatchRecursiveAddsFilesInNewSubdir(t *testing.T) {
+
+	dir := t.TempDir()
+
+	c := New()
+	if err := c.AddDir("assets", dir, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Give the watcher time to notice and register the new subdir
+	// before a file lands in it -- registration happens only after
+	// the Create event clears watchDebounce.
+	time.Sleep(200 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(sub, "f.txt"), []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Err != nil {
+			t.Fatalf("unexpected event error: %v", ev.Err)
+		}
+		if ev.Alias != "assets/sub/f.txt" || ev.Op != Added {
+			t.Fatalf("got event %+v, want Added assets/sub/f.txt", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for recursive add event")
+	}
+}
+
+// TestWatchNonRecursiveIgnoresNewSubdir covers a dirWatch with
+// recursive=false: a file created inside a brand-new subdirectory
+// must NOT surface an Event, since only dir itself is watched.
+func TestWatchNonRecursiveIgnoresNewSubdir(t *testing.T) {
+
+	dir := t.TempDir()
+
+	c := New()
+	if err := c.AddDir("assets", dir, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(sub, "f.txt"), []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ev := <-events:
+		t.Fatalf("got unexpected event %+v, want none (non-recursive)", ev)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestUnderDir(t *testing.T) {
+	cases := []struct {
+		p, root string
+		want    bool
+	}{
+		{"/assets", "/assets", true},
+		{"/assets/foo.css", "/assets", true},
+		{"/assets-backup/foo.css", "/assets", false},
+		{"/assets-backup", "/assets", false},
+		{"/other/foo.css", "/assets", false},
+	}
+	for _, c := range cases {
+		if got := underDir(c.p, c.root); got != c.want {
+			t.Errorf("underDir(%q, %q) = %v, want %v", c.p, c.root, got, c.want)
+		}
+	}
+}